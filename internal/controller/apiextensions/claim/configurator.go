@@ -0,0 +1,475 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package claim manages the lifecycle of a composite resource claim.
+package claim
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/crossplane/crossplane-runtime/pkg/meta"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/claim"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/composite"
+
+	"github.com/crossplane/crossplane/internal/xcrd"
+)
+
+// AnnotationKeyLastAppliedConfiguration is added to a composite resource to
+// record the claim spec that was last propagated onto it. The claim itself
+// records the status it last received under AnnotationKeyLastAppliedStatus
+// instead. It lets us compute a three-way merge the
+// next time we reconcile, rather than a destructive two-way copy that would
+// silently discard concurrent edits made directly to the other side.
+const AnnotationKeyLastAppliedConfiguration = "crossplane.io/last-applied-configuration"
+
+// AnnotationKeyLastAppliedStatus is added to a claim to record the
+// composite resource status that was last propagated onto it. It lets us
+// compute a three-way merge the next time we reconcile its status, the same
+// way AnnotationKeyLastAppliedConfiguration lets us do so for its spec.
+const AnnotationKeyLastAppliedStatus = "crossplane.io/last-applied-status"
+
+// Error strings.
+const (
+	errUnsupportedClaimSpec = "composite resource claim's spec was not an object"
+
+	errUnsupportedDstObject = "destination object was not an object"
+	errUnsupportedSrcObject = "source object was not an object"
+
+	errMergeClaimSpec   = "unable to merge claim spec"
+	errMergeClaimStatus = "unable to merge claim status"
+
+	errUpdateClaim       = "unable to update claim"
+	errUpdateClaimStatus = "unable to update claim status"
+
+	errExternalNameMismatch = "cannot change external name annotation value after claim's composite resource is bound"
+)
+
+// compositeOnlySpecFields are claim spec fields that exist only to reference
+// the composite resource, or (in the case of overlays) only to describe how
+// the claim's own spec was assembled, and must never be copied onto it.
+var compositeOnlySpecFields = map[string]bool{
+	"resourceRef":                true,
+	"writeConnectionSecretToRef": true,
+	"overlays":                   true,
+}
+
+// claimExcludedLateInitFields are composite resource spec fields that must
+// never be late-initialized onto a claim, because they're internal
+// bookkeeping the composite uses to track its own resources.
+var claimExcludedLateInitFields = map[string]bool{
+	"resourceRefs":               true,
+	"claimRef":                   true,
+	"writeConnectionSecretToRef": true,
+}
+
+// claimOwnedStatusFields are claim status fields whose value the claim owns
+// and that must never be clobbered by the composite resource's status.
+var claimOwnedStatusFields = map[string]bool{
+	"conditions": true,
+}
+
+// A CompositeConfigurator configures a composite resource derived from a
+// claim, across four phases run in a fixed order: PreConfigure, then
+// ConfigureMetadata, then ConfigureSpec, then PostConfigure. Splitting the
+// work this way lets a downstream consumer plug in a configurator that only
+// cares about one phase - e.g. a PostConfigure that stamps labels from a
+// ControllerConfig - without having to reimplement the others. Embed
+// NopCompositeConfigurator to satisfy phases you don't need.
+type CompositeConfigurator interface {
+	// PreConfigure runs before any other phase, and before cp has been
+	// touched. It's the place for validation that should block every
+	// later phase.
+	PreConfigure(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) error
+
+	// ConfigureMetadata sets metadata - labels, annotations, generateName -
+	// on cp.
+	ConfigureMetadata(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) error
+
+	// ConfigureSpec sets cp's spec from cm's. It returns the claim spec
+	// fields that were rejected by policy, and any that conflicted between
+	// cm and cp since the last reconcile, so the chain can surface them as
+	// claim conditions once every configurator's ConfigureSpec has run.
+	ConfigureSpec(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) (rejected, conflicts []string, err error)
+
+	// PostConfigure runs last, once every configurator's metadata and spec
+	// phases have completed.
+	PostConfigure(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) error
+}
+
+// NopCompositeConfigurator implements CompositeConfigurator by doing
+// nothing in every phase. Embed it in a CompositeConfigurator that only
+// needs to implement a subset of the phases.
+type NopCompositeConfigurator struct{}
+
+// PreConfigure does nothing.
+func (NopCompositeConfigurator) PreConfigure(_ context.Context, _ resource.CompositeClaim, _ resource.Composite) error {
+	return nil
+}
+
+// ConfigureMetadata does nothing.
+func (NopCompositeConfigurator) ConfigureMetadata(_ context.Context, _ resource.CompositeClaim, _ resource.Composite) error {
+	return nil
+}
+
+// ConfigureSpec does nothing.
+func (NopCompositeConfigurator) ConfigureSpec(_ context.Context, _ resource.CompositeClaim, _ resource.Composite) (rejected, conflicts []string, err error) {
+	return nil, nil, nil
+}
+
+// PostConfigure does nothing.
+func (NopCompositeConfigurator) PostConfigure(_ context.Context, _ resource.CompositeClaim, _ resource.Composite) error {
+	return nil
+}
+
+// A CompositeConfiguratorChain runs an ordered sequence of
+// CompositeConfigurators. It runs every configurator's PreConfigure, then
+// every ConfigureMetadata, then every ConfigureSpec, then every
+// PostConfigure - rather than running each configurator's phases back to
+// back - so that (for example) one configurator's metadata changes are
+// visible to the next configurator's spec phase. This mirrors how a chain
+// of mutating admission webhooks runs against the same object.
+type CompositeConfiguratorChain []CompositeConfigurator
+
+// DefaultCompositeConfiguratorChain returns the CompositeConfiguratorChain
+// that reproduces ConfigureComposite's historical behavior: set
+// generateName, add claim namespace and name labels, copy the claim's
+// annotations, propagate spec fields per the supplied PropagationPolicySet
+// using a three-way merge, and reject any claim that changes an Immutable
+// field.
+func DefaultCompositeConfiguratorChain(p PropagationPolicySet) CompositeConfiguratorChain {
+	return CompositeConfiguratorChain{&defaultCompositeConfigurator{policy: p}}
+}
+
+// Configure runs every phase of every configurator in the chain, in order.
+// Once every ConfigureSpec has run, and before any PostConfigure runs, it
+// surfaces any rejected or conflicting fields as conditions on cm.
+func (cc CompositeConfiguratorChain) Configure(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) error {
+	for _, c := range cc {
+		if err := c.PreConfigure(ctx, cm, cp); err != nil {
+			return err
+		}
+	}
+	for _, c := range cc {
+		if err := c.ConfigureMetadata(ctx, cm, cp); err != nil {
+			return err
+		}
+	}
+
+	var rejected, conflicts []string
+	for _, c := range cc {
+		r, cf, err := c.ConfigureSpec(ctx, cm, cp)
+		if err != nil {
+			return err
+		}
+		rejected = append(rejected, r...)
+		conflicts = append(conflicts, cf...)
+	}
+	if len(rejected) > 0 {
+		cm.SetConditions(invalidPropagation(rejected))
+	}
+	if len(conflicts) > 0 {
+		cm.SetConditions(conflictingUpdate(conflicts))
+	}
+
+	for _, c := range cc {
+		if err := c.PostConfigure(ctx, cm, cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultCompositeConfigurator implements the historical ConfigureComposite
+// behavior as a CompositeConfigurator.
+type defaultCompositeConfigurator struct {
+	policy PropagationPolicySet
+}
+
+// PreConfigure does nothing; the default configurator has no validation
+// that must block other configurators in the chain.
+func (d *defaultCompositeConfigurator) PreConfigure(_ context.Context, _ resource.CompositeClaim, _ resource.Composite) error {
+	return nil
+}
+
+// ConfigureMetadata sets cp's generateName (if it doesn't yet have a name),
+// claim namespace and name labels, and annotations - rejecting a claim that
+// tries to change an external name that's already taken effect.
+func (d *defaultCompositeConfigurator) ConfigureMetadata(_ context.Context, cm resource.CompositeClaim, cp resource.Composite) error {
+	if cp.GetName() == "" {
+		// The composite resource doesn't exist yet; it's being dynamically
+		// provisioned, so ask the API server to generate its name.
+		cp.SetGenerateName(fmt.Sprintf("%s-", cm.GetName()))
+	}
+	meta.AddLabels(cp, map[string]string{
+		xcrd.LabelKeyClaimNamespace: cm.GetNamespace(),
+		xcrd.LabelKeyClaimName:      cm.GetName(),
+	})
+
+	if _, ok := cm.(*claim.Unstructured); !ok {
+		return nil
+	}
+	if _, ok := cp.(*composite.Unstructured); !ok {
+		return nil
+	}
+
+	// The claim may already have an external name annotation (e.g. because
+	// it references a statically provisioned composite resource). If the
+	// composite resource already has its own external name recorded it's
+	// most likely already taken effect with an external system, so it can't
+	// be changed retroactively - but we still want the claim's other
+	// annotations to propagate.
+	existing := meta.GetExternalName(cp)
+	meta.AddAnnotations(cp, cm.GetAnnotations())
+	if existing != "" && existing != meta.GetExternalName(cm) {
+		return errors.New(errExternalNameMismatch)
+	}
+	return nil
+}
+
+// ConfigureSpec sets cp's spec using a three-way merge of the claim's
+// last-applied spec (if any), its current spec, and cp's current spec. This
+// allows a field that was changed directly on cp to survive a reconcile,
+// provided the claim didn't independently change the same field. d's
+// PropagationPolicySet overrides this default claim-wins behavior on a
+// per-field basis. ConfigureSpec is a no-op if cm and cp are not both
+// unstructured.
+func (d *defaultCompositeConfigurator) ConfigureSpec(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) (rejected, conflicts []string, err error) { //nolint:gocyclo // This is a straightforward sequence of fairly simple checks.
+	cmu, ok := cm.(*claim.Unstructured)
+	if !ok {
+		return nil, nil, nil
+	}
+	cpu, ok := cp.(*composite.Unstructured)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	// An APICompositeConfigurator may have resolved cm's spec.overlays into
+	// an effective spec that's distinct from cm's own stored spec; prefer
+	// that if present so a thin claim's overridden fields don't get its
+	// bases' defaults baked into cm itself.
+	cmSpec, ok := overlaySpecFromContext(ctx)
+	if !ok {
+		cmSpec, ok = cmu.Object["spec"].(map[string]interface{})
+		if !ok {
+			return nil, nil, errors.New(errUnsupportedClaimSpec)
+		}
+	}
+	cmSpec = withoutFields(cmSpec, compositeOnlySpecFields)
+
+	cpSpec, _ := cpu.Object["spec"].(map[string]interface{})
+	if cpSpec == nil {
+		cpSpec = map[string]interface{}{}
+	}
+
+	last := lastAppliedConfiguration(cp)
+
+	for field := range cmSpec {
+		switch d.policy.ModeFor(field) {
+		case PropagationModeRejected:
+			rejected = append(rejected, field)
+			delete(cmSpec, field)
+		case PropagationModeCompositeToClaim, PropagationModeLateInitClaimOnly:
+			// These fields are never set on the composite resource by the
+			// claim; leave whatever value the composite resource has.
+			delete(cmSpec, field)
+		case PropagationModeImmutable:
+			if last == nil {
+				continue
+			}
+			if lv, hadLast := last[field]; hadLast && !equalJSON(lv, cmSpec[field]) {
+				return rejected, conflicts, errors.Errorf(errImmutableFieldChanged, field)
+			}
+		case PropagationModeClaimToComposite:
+			// The default behavior; nothing to do here.
+		}
+	}
+
+	// A field may have been recorded in last under ClaimToComposite before
+	// its policy changed to one of these modes. Without this, the next
+	// three-way merge would see the field vanish from cmSpec and, finding
+	// cp's value still matches last, delete it outright - silently
+	// discarding a value that's now owned by the composite resource (or
+	// another field owner) instead of leaving it alone.
+	for field := range last {
+		switch d.policy.ModeFor(field) {
+		case PropagationModeCompositeToClaim, PropagationModeLateInitClaimOnly, PropagationModeRejected:
+			delete(last, field)
+		}
+	}
+
+	merged, c := threeWayMergeSpec(last, cmSpec, cpSpec)
+	cpu.Object["spec"] = merged
+	conflicts = c
+
+	return rejected, conflicts, setLastAppliedConfiguration(cp, cmSpec)
+}
+
+// PostConfigure does nothing; the default configurator has no work left to
+// do once cp's metadata and spec are configured.
+func (d *defaultCompositeConfigurator) PostConfigure(_ context.Context, _ resource.CompositeClaim, _ resource.Composite) error {
+	return nil
+}
+
+// ConfigureComposite configures the supplied composite resource, using a
+// three-way merge of the claim's last-applied spec (if any), its current
+// spec, and the composite resource's current spec. This allows a field that
+// was changed directly on the composite resource to survive a reconcile,
+// provided the claim didn't independently change the same field. The
+// supplied PropagationPolicySet overrides this default claim-wins behavior
+// on a per-field basis. If the supplied claim and composite resource are not
+// both unstructured, ConfigureComposite only sets the composite resource's
+// generateName (if it has no name) and its claim namespace and name labels.
+//
+// ConfigureComposite runs the DefaultCompositeConfiguratorChain. Use
+// NewAPICompositeConfigurator to run a chain that also resolves overlays,
+// or that includes custom CompositeConfigurators.
+func ConfigureComposite(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite, p PropagationPolicySet) error {
+	return DefaultCompositeConfiguratorChain(p).Configure(ctx, cm, cp)
+}
+
+// An APICompositeConfigurator configures a composite resource, typically
+// deriving its values from a claim. Unlike the ConfigureComposite function
+// it also resolves any base claims the claim overlays before doing so, and
+// supports appending custom CompositeConfigurators to the default chain.
+type APICompositeConfigurator struct {
+	client client.Client
+	events event.Recorder
+	chain  CompositeConfiguratorChain
+}
+
+// NewAPICompositeConfigurator returns a Configurator that configures a
+// composite resource using the supplied client, enforcing the supplied
+// PropagationPolicySet and emitting events via the supplied Recorder. Any
+// extra CompositeConfigurators are appended to the default chain, in order;
+// in each phase they run after the default configurator's same phase - e.g.
+// to stamp additional labels derived from a ControllerConfig, enforce a
+// naming convention, or reject a claim outright.
+//
+// p is built by the caller; this package does not read propagation
+// policies from a CompositeResourceDefinition.
+func NewAPICompositeConfigurator(c client.Client, p PropagationPolicySet, e event.Recorder, extra ...CompositeConfigurator) *APICompositeConfigurator {
+	chain := append(DefaultCompositeConfiguratorChain(p), extra...)
+	return &APICompositeConfigurator{client: c, events: e, chain: chain}
+}
+
+// Configure the supplied composite resource according to cm, first
+// resolving any base claims referenced by cm's spec.overlays. cm's own
+// stored spec is left untouched; the overlay-resolved spec is used only to
+// configure cp, so a thin claim's overridden fields don't get its bases'
+// defaults permanently baked into it.
+func (c *APICompositeConfigurator) Configure(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) error {
+	if cmu, ok := cm.(*claim.Unstructured); ok {
+		spec, err := c.resolveOverlays(ctx, cmu)
+		if err != nil {
+			return err
+		}
+		ctx = withOverlaySpec(ctx, spec)
+	}
+	return c.chain.Configure(ctx, cm, cp)
+}
+
+// An APIClaimConfigurator configures a claim, typically deriving its values
+// from a composite resource.
+type APIClaimConfigurator struct {
+	client client.Client
+	policy PropagationPolicySet
+}
+
+// NewAPIClaimConfigurator returns a Configurator that configures a claim
+// using the supplied client, enforcing the supplied PropagationPolicySet.
+//
+// p is built by the caller; this package does not read propagation
+// policies from a CompositeResourceDefinition.
+func NewAPIClaimConfigurator(c client.Client, p PropagationPolicySet) *APIClaimConfigurator {
+	return &APIClaimConfigurator{client: c, policy: p}
+}
+
+// Configure any required fields that were omitted from the claim by using
+// the corresponding field on the composite resource (i.e. late-init), then
+// updates the claim's status to reflect the status of the composite
+// resource. Configure is a no-op if the supplied claim and composite
+// resource are not both unstructured.
+func (c *APIClaimConfigurator) Configure(ctx context.Context, cm resource.CompositeClaim, cp resource.Composite) error {
+	cmu, ok := cm.(*claim.Unstructured)
+	if !ok {
+		return nil
+	}
+	cpu, ok := cp.(*composite.Unstructured)
+	if !ok {
+		return nil
+	}
+
+	// The composite resource's status is merged onto the claim's, except
+	// for fields (like its conditions) that the claim owns independently. A
+	// three-way merge - rather than a destructive overwrite - lets a field
+	// set directly on the claim's status survive a reconcile. Once a
+	// last-applied status is recorded, the claim keeps its value for a
+	// recorded field whenever that value differs from the recorded one,
+	// even if the composite resource has also changed the field; until
+	// then, every composite resource status field overwrites the claim's.
+	// This is the same merge ConfigureSpec uses for the claim's spec, except
+	// that conflicts are not reported.
+	cmStatus, err := asObject(cmu.Object["status"], errUnsupportedDstObject)
+	if err != nil {
+		return errors.Wrap(err, errMergeClaimStatus)
+	}
+	cpStatus, err := asObject(cpu.Object["status"], errUnsupportedSrcObject)
+	if err != nil {
+		return errors.Wrap(err, errMergeClaimStatus)
+	}
+	cpStatus = withoutFields(cpStatus, claimOwnedStatusFields)
+
+	merged, _ := threeWayMergeSpec(lastAppliedStatus(cmu), cpStatus, withoutFields(cmStatus, claimOwnedStatusFields))
+	for field := range claimOwnedStatusFields {
+		if v, ok := cmStatus[field]; ok {
+			merged[field] = v
+		}
+	}
+	cmu.Object["status"] = merged
+
+	if err := setLastAppliedStatus(cmu, cpStatus); err != nil {
+		return errors.Wrap(err, errMergeClaimStatus)
+	}
+
+	if err := c.client.Status().Update(ctx, cm); err != nil {
+		return errors.Wrap(err, errUpdateClaimStatus)
+	}
+
+	spec, err := lateInitializeSpec(cmu.Object["spec"], cpu.Object["spec"], claimExcludedLateInitFields)
+	if err != nil {
+		return errors.Wrap(err, errMergeClaimSpec)
+	}
+	if cpSpec, ok := cpu.Object["spec"].(map[string]interface{}); ok {
+		for field, value := range cpSpec {
+			if c.policy.ModeFor(field) == PropagationModeCompositeToClaim {
+				spec[field] = value
+			}
+		}
+	}
+	cmu.Object["spec"] = spec
+
+	if err := c.client.Update(ctx, cm); err != nil {
+		return errors.Wrap(err, errUpdateClaim)
+	}
+
+	return nil
+}
@@ -43,9 +43,10 @@ func TestCompositeConfigure(t *testing.T) {
 	now := metav1.Now()
 
 	type args struct {
-		ctx context.Context
-		cm  resource.CompositeClaim
-		cp  resource.Composite
+		ctx    context.Context
+		cm     resource.CompositeClaim
+		cp     resource.Composite
+		policy PropagationPolicySet
 	}
 
 	type want struct {
@@ -177,6 +178,12 @@ func TestCompositeConfigure(t *testing.T) {
 									xcrd.LabelKeyClaimNamespace: ns,
 									xcrd.LabelKeyClaimName:      name,
 								},
+								"annotations": map[string]interface{}{
+									// Recorded so a future reconcile can tell
+									// which fields the claim changed, versus a
+									// field changed directly on the composite.
+									AnnotationKeyLastAppliedConfiguration: `{"compositionRef":"ref","compositionSelector":"ref","coolness":23}`,
+								},
 							},
 							"spec": map[string]interface{}{
 								"coolness":            23,
@@ -188,6 +195,111 @@ func TestCompositeConfigure(t *testing.T) {
 				},
 			},
 		},
+		"FiltersOverlaysFromComposite": {
+			reason: "spec.overlays is claim-layering metadata; it has no corresponding field on the composite resource and must never be copied onto it",
+			args: args{
+				cm: &claim.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"namespace": ns,
+								"name":      name,
+							},
+							"spec": map[string]interface{}{
+								"coolness": 23,
+								"overlays": []interface{}{
+									map[string]interface{}{"namespace": ns, "name": "base"},
+								},
+							},
+						},
+					},
+				},
+				cp: &composite.Unstructured{},
+			},
+			want: want{
+				cp: &composite.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"generateName": name + "-",
+								"labels": map[string]interface{}{
+									xcrd.LabelKeyClaimNamespace: ns,
+									xcrd.LabelKeyClaimName:      name,
+								},
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedConfiguration: `{"coolness":23}`,
+								},
+							},
+							"spec": map[string]interface{}{
+								"coolness": 23,
+							},
+						},
+					},
+				},
+			},
+		},
+		"PreservesFieldChangedDirectlyOnXR": {
+			reason: "A field that was changed directly on the composite resource, and that the claim hasn't touched since the last reconcile, should survive a three-way merge",
+			args: args{
+				cm: &claim.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"namespace": ns,
+								"name":      name,
+							},
+							"spec": map[string]interface{}{
+								"coolness": 23,
+							},
+						},
+					},
+				},
+				cp: &composite.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"name": name,
+								"annotations": map[string]interface{}{
+									// Recorded the last time we reconciled;
+									// the claim hasn't changed coolness since.
+									AnnotationKeyLastAppliedConfiguration: `{"coolness":23}`,
+								},
+							},
+							"spec": map[string]interface{}{
+								"coolness": 23,
+
+								// Set directly on the XR since the last
+								// reconcile. It should survive, because the
+								// claim never owned or changed this field.
+								"tier": "premium",
+							},
+						},
+					},
+				},
+			},
+			want: want{
+				cp: &composite.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"name": name,
+								"labels": map[string]interface{}{
+									xcrd.LabelKeyClaimNamespace: ns,
+									xcrd.LabelKeyClaimName:      name,
+								},
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedConfiguration: `{"coolness":23}`,
+								},
+							},
+							"spec": map[string]interface{}{
+								"coolness": 23,
+								"tier":     "premium",
+							},
+						},
+					},
+				},
+			},
+		},
 		"ConfiguredExistingXR": {
 			reason: "A statically provisioned composite resource should be configured according to the claim",
 			args: args{
@@ -258,9 +370,10 @@ func TestCompositeConfigure(t *testing.T) {
 									xcrd.LabelKeyClaimName:      name,
 								},
 								"annotations": map[string]interface{}{
-									meta.AnnotationKeyExternalName: name,
-									"xr":                           "annotation",
-									"xrc":                          "annotation",
+									meta.AnnotationKeyExternalName:        name,
+									"xr":                                  "annotation",
+									"xrc":                                 "annotation",
+									AnnotationKeyLastAppliedConfiguration: `{"coolness":23}`,
 								},
 							},
 							"spec": map[string]interface{}{
@@ -355,11 +468,113 @@ func TestCompositeConfigure(t *testing.T) {
 				},
 			},
 		},
+		"RejectedFieldPolicy": {
+			reason: "A claim field governed by a Rejected propagation policy should never reach the composite resource",
+			args: args{
+				cm: &claim.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"namespace": ns,
+								"name":      name,
+							},
+							"spec": map[string]interface{}{
+								"coolness": 23,
+								"tier":     "premium", // governed by a Rejected policy below
+							},
+						},
+					},
+				},
+				cp: &composite.Unstructured{},
+				policy: PropagationPolicySet{
+					{Path: "tier", Mode: PropagationModeRejected},
+				},
+			},
+			want: want{
+				cp: &composite.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"generateName": name + "-",
+								"labels": map[string]interface{}{
+									xcrd.LabelKeyClaimNamespace: ns,
+									xcrd.LabelKeyClaimName:      name,
+								},
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedConfiguration: `{"coolness":23}`,
+								},
+							},
+							"spec": map[string]interface{}{
+								"coolness": 23,
+							},
+						},
+					},
+				},
+			},
+		},
+		"ImmutableFieldPolicyViolation": {
+			reason: "Changing a claim field governed by an Immutable propagation policy after it was first applied is an error",
+			args: args{
+				cm: &claim.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"namespace": ns,
+								"name":      name,
+							},
+							"spec": map[string]interface{}{
+								"tier": "standard", // was "premium" at the last reconcile
+							},
+						},
+					},
+				},
+				cp: &composite.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"name": name,
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedConfiguration: `{"tier":"premium"}`,
+								},
+							},
+							"spec": map[string]interface{}{
+								"tier": "premium",
+							},
+						},
+					},
+				},
+				policy: PropagationPolicySet{
+					{Path: "tier", Mode: PropagationModeImmutable},
+				},
+			},
+			want: want{
+				err: errors.Errorf(errImmutableFieldChanged, "tier"),
+				cp: &composite.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"name": name,
+								"labels": map[string]interface{}{
+									xcrd.LabelKeyClaimNamespace: ns,
+									xcrd.LabelKeyClaimName:      name,
+								},
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedConfiguration: `{"tier":"premium"}`,
+								},
+							},
+							"spec": map[string]interface{}{
+								"tier": "premium", // unchanged, since the claim's change was rejected
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			got := ConfigureComposite(tc.args.ctx, tc.args.cm, tc.args.cp)
+			got := ConfigureComposite(tc.args.ctx, tc.args.cm, tc.args.cp, tc.args.policy)
 			if diff := cmp.Diff(tc.want.err, got, test.EquateErrors()); diff != "" {
 				t.Errorf("ConfigureComposite(...): %s\n-want error, +got error:\n%s\n", tc.reason, diff)
 			}
@@ -380,6 +595,7 @@ func TestClaimConfigure(t *testing.T) {
 		cm     resource.CompositeClaim
 		cp     resource.Composite
 		client client.Client
+		policy PropagationPolicySet
 	}
 
 	type want struct {
@@ -477,6 +693,11 @@ func TestClaimConfigure(t *testing.T) {
 				cm: &claim.Unstructured{
 					Unstructured: unstructured.Unstructured{
 						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedStatus: `{}`,
+								},
+							},
 							"spec":   map[string]interface{}{},
 							"status": map[string]interface{}{},
 						},
@@ -512,6 +733,11 @@ func TestClaimConfigure(t *testing.T) {
 				cm: &claim.Unstructured{
 					Unstructured: unstructured.Unstructured{
 						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedStatus: `{}`,
+								},
+							},
 							"spec":   "notSpec",
 							"status": map[string]interface{}{},
 						},
@@ -548,6 +774,11 @@ func TestClaimConfigure(t *testing.T) {
 				cm: &claim.Unstructured{
 					Unstructured: unstructured.Unstructured{
 						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedStatus: `{}`,
+								},
+							},
 							"spec":   map[string]interface{}{},
 							"status": map[string]interface{}{},
 						},
@@ -602,6 +833,9 @@ func TestClaimConfigure(t *testing.T) {
 							"metadata": map[string]interface{}{
 								"namespace": ns,
 								"name":      name,
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedStatus: `{}`,
+								},
 							},
 							"spec": map[string]interface{}{
 								"someField":                  "someValue",
@@ -671,6 +905,13 @@ func TestClaimConfigure(t *testing.T) {
 							"metadata": map[string]interface{}{
 								"namespace": ns,
 								"name":      name,
+								"annotations": map[string]interface{}{
+									// Recorded so a future reconcile can tell
+									// which status fields the claim itself
+									// changed, versus ones changed by the
+									// composite resource.
+									AnnotationKeyLastAppliedStatus: `{"previousCoolness":28}`,
+								},
 							},
 							"spec": map[string]interface{}{
 								"resourceRef":                "ref",
@@ -689,11 +930,69 @@ func TestClaimConfigure(t *testing.T) {
 				},
 			},
 		},
+		"CompositeToClaimPolicy": {
+			reason: "A composite resource field governed by a CompositeToClaim propagation policy should overwrite the claim's own value",
+			args: args{
+				client: test.NewMockClient(),
+				policy: PropagationPolicySet{
+					{Path: "region", Mode: PropagationModeCompositeToClaim},
+				},
+				cm: &claim.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"namespace": ns,
+								"name":      name,
+							},
+							"spec": map[string]interface{}{
+								"region": "us-east-1",
+							},
+							"status": map[string]interface{}{},
+						},
+					},
+				},
+				cp: &composite.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"namespace": ns,
+								"name":      name + "-12345",
+							},
+							"spec": map[string]interface{}{
+								// The provider chose this region for us; it
+								// should flow back down onto the claim.
+								"region": "us-east-1b",
+							},
+							"status": map[string]interface{}{},
+						},
+					},
+				},
+			},
+			want: want{
+				cm: &claim.Unstructured{
+					Unstructured: unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"namespace": ns,
+								"name":      name,
+								"annotations": map[string]interface{}{
+									AnnotationKeyLastAppliedStatus: `{}`,
+								},
+							},
+							"spec": map[string]interface{}{
+								"region": "us-east-1b",
+							},
+							"status": map[string]interface{}{},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			c := NewAPIClaimConfigurator(tc.args.client)
+			c := NewAPIClaimConfigurator(tc.args.client, tc.args.policy)
 			got := c.Configure(context.Background(), tc.args.cm, tc.args.cp)
 			if diff := cmp.Diff(tc.want.err, got, test.EquateErrors()); diff != "" {
 				t.Errorf("c.Configure(...): %s\n-want error, +got error:\n%s\n", tc.reason, diff)
@@ -705,3 +1004,62 @@ func TestClaimConfigure(t *testing.T) {
 	}
 
 }
+
+// rejectingCompositeConfigurator is a CompositeConfigurator that rejects any
+// claim whose spec sets a field not in its allow-list. It demonstrates how a
+// downstream consumer can plug its own policy into a CompositeConfiguratorChain
+// without forking the default configurator.
+type rejectingCompositeConfigurator struct {
+	NopCompositeConfigurator
+
+	allowed map[string]bool
+}
+
+const errForbiddenField = "claim spec set a field that's forbidden by local policy: %q"
+
+func (r *rejectingCompositeConfigurator) PreConfigure(_ context.Context, cm resource.CompositeClaim, _ resource.Composite) error {
+	cmu, ok := cm.(*claim.Unstructured)
+	if !ok {
+		return nil
+	}
+	spec, ok := cmu.Object["spec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for field := range spec {
+		if !r.allowed[field] {
+			return errors.Errorf(errForbiddenField, field)
+		}
+	}
+	return nil
+}
+
+func TestCompositeConfiguratorChain(t *testing.T) {
+	ns := "spacename"
+	name := "cool"
+
+	cm := &claim.Unstructured{
+		Unstructured: unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"namespace": ns,
+					"name":      name,
+				},
+				"spec": map[string]interface{}{
+					"region":  "us-east-1",
+					"storage": "100Gi",
+				},
+			},
+		},
+	}
+	cp := &composite.Unstructured{Unstructured: unstructured.Unstructured{Object: map[string]interface{}{}}}
+
+	chain := append(DefaultCompositeConfiguratorChain(nil), &rejectingCompositeConfigurator{
+		allowed: map[string]bool{"region": true},
+	})
+
+	err := chain.Configure(context.Background(), cm, cp)
+	if diff := cmp.Diff(errors.Errorf(errForbiddenField, "storage"), err, test.EquateErrors()); diff != "" {
+		t.Errorf("chain.Configure(...): -want error, +got error:\n%s\n", diff)
+	}
+}
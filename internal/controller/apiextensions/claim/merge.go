@@ -0,0 +1,236 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claim
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/pkg/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/meta"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+)
+
+// ReasonConflictingUpdate is added to a claim's Synced condition when a
+// three-way merge detects that a field was changed both on the claim and on
+// its composite resource since the last reconcile.
+const ReasonConflictingUpdate xpv1.ConditionReason = "ConflictingUpdate"
+
+// conflictingUpdate reports a condition explaining which fields could not be
+// reconciled because they were changed on both sides of the claim <->
+// composite relationship since the last reconcile.
+func conflictingUpdate(fields []string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:    xpv1.TypeSynced,
+		Status:  corev1.ConditionFalse,
+		Reason:  ReasonConflictingUpdate,
+		Message: "Fields were changed on both the claim and its composite resource since the last reconcile, and the composite resource's values were kept: " + strings.Join(fields, ", "),
+	}
+}
+
+// threeWayMergeSpec merges current, the claim (or composite resource)'s
+// current configuration, onto dst, the other side's current configuration,
+// using last, the configuration that was last successfully applied, to
+// distinguish an intentional edit from a stale value.
+//
+// A field that's unchanged between last and current is left alone on dst,
+// so that a value set directly on dst survives. A field that's been removed
+// from current since last is deleted from dst, unless dst has itself
+// diverged from last. A field changed on both sides is a conflict: dst's
+// value is kept, and the field is reported so the caller can surface it.
+func threeWayMergeSpec(last, current, dst map[string]interface{}) (map[string]interface{}, []string) {
+	out := make(map[string]interface{}, len(dst))
+	for k, v := range dst {
+		out[k] = v
+	}
+
+	if last == nil {
+		// We have nothing to diff against - this is effectively a first
+		// apply, so the incoming configuration always wins.
+		for k, v := range current {
+			out[k] = v
+		}
+		return out, nil
+	}
+
+	var conflicts []string
+	for k, cv := range current {
+		lv, hadLast := last[k]
+		changed := !hadLast || !equalJSON(lv, cv)
+		if !changed {
+			// current didn't touch this field since the last reconcile;
+			// leave whatever is on dst alone.
+			continue
+		}
+
+		if dv, hadDst := out[k]; hadDst && hadLast && !equalJSON(dv, lv) {
+			// dst has also diverged from last for this field - keep dst's
+			// value and report the conflict.
+			conflicts = append(conflicts, k)
+			continue
+		}
+
+		out[k] = cv
+	}
+
+	for k, lv := range last {
+		if _, stillPresent := current[k]; stillPresent {
+			continue
+		}
+		// The field was removed since the last reconcile. Delete it from
+		// dst too, unless dst has since diverged from the last-applied
+		// value - in which case that's an intentional change we shouldn't
+		// clobber.
+		if dv, ok := out[k]; ok && equalJSON(dv, lv) {
+			delete(out, k)
+		}
+	}
+
+	return out, conflicts
+}
+
+// equalJSON reports whether a and b marshal to the same JSON, which lets us
+// compare values that may have come from a literal Go map (e.g. an int) with
+// ones that were round-tripped through JSON (e.g. a float64) without caring
+// about the difference.
+func equalJSON(a, b interface{}) bool {
+	aj, aerr := json.Marshal(a)
+	bj, berr := json.Marshal(b)
+	if aerr != nil || berr != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return string(aj) == string(bj)
+}
+
+// asObject type-asserts v as a map[string]interface{}, returning a nil map
+// (not an error) if v itself is nil. v being some other, non-map type is an
+// error, reported using errMsg.
+func asObject(v interface{}, errMsg string) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(errMsg)
+	}
+	return m, nil
+}
+
+// lastApplied returns the map that was recorded in o's annotation key, or
+// nil if it has none (or the recorded value can't be parsed).
+func lastApplied(o resource.Object, key string) map[string]interface{} {
+	raw, ok := o.GetAnnotations()[key]
+	if !ok {
+		return nil
+	}
+	last := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(raw), &last); err != nil {
+		return nil
+	}
+	return last
+}
+
+// setLastApplied records v under o's annotation key, so that the next
+// reconcile can compute a three-way merge against it.
+func setLastApplied(o resource.Object, key string, v map[string]interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	meta.AddAnnotations(o, map[string]string{key: string(b)})
+	return nil
+}
+
+// lastAppliedConfiguration returns the spec that was recorded in o's
+// AnnotationKeyLastAppliedConfiguration annotation, or nil if it has none
+// (or the recorded value can't be parsed).
+func lastAppliedConfiguration(o resource.Object) map[string]interface{} {
+	return lastApplied(o, AnnotationKeyLastAppliedConfiguration)
+}
+
+// setLastAppliedConfiguration records spec as o's last-applied
+// configuration, so that the next reconcile can compute a three-way merge
+// against it.
+func setLastAppliedConfiguration(o resource.Object, spec map[string]interface{}) error {
+	return setLastApplied(o, AnnotationKeyLastAppliedConfiguration, spec)
+}
+
+// lastAppliedStatus returns the status that was recorded in o's
+// AnnotationKeyLastAppliedStatus annotation, or nil if it has none (or the
+// recorded value can't be parsed).
+func lastAppliedStatus(o resource.Object) map[string]interface{} {
+	return lastApplied(o, AnnotationKeyLastAppliedStatus)
+}
+
+// setLastAppliedStatus records status as o's last-applied status, so that
+// the next reconcile can compute a three-way merge against it.
+func setLastAppliedStatus(o resource.Object, status map[string]interface{}) error {
+	return setLastApplied(o, AnnotationKeyLastAppliedStatus, status)
+}
+
+// lateInitializeSpec fills any field that's missing from dst with the
+// equivalent field from src, except for the supplied excluded fields. dst
+// and src must each be a map[string]interface{} or nil, or an error is
+// returned describing which one wasn't. A nil dst is treated as empty.
+func lateInitializeSpec(dst, src interface{}, exclude map[string]bool) (map[string]interface{}, error) {
+	d, ok := dst.(map[string]interface{})
+	if !ok {
+		if dst != nil {
+			return nil, errors.New(errUnsupportedDstObject)
+		}
+		d = map[string]interface{}{}
+	}
+
+	if src == nil {
+		return d, nil
+	}
+	s, ok := src.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(errUnsupportedSrcObject)
+	}
+
+	out := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	for k, v := range s {
+		if exclude[k] {
+			continue
+		}
+		if _, set := out[k]; set {
+			continue
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// withoutFields returns a shallow copy of in with the supplied keys removed.
+func withoutFields(in map[string]interface{}, exclude map[string]bool) map[string]interface{} {
+	out := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		if exclude[k] {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claim
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/claim"
+)
+
+// maxOverlayDepth bounds how many levels of spec.overlays we'll follow
+// before giving up, so that a long (but non-cyclic) chain of base claims
+// can't make a reconcile run away.
+const maxOverlayDepth = 8
+
+// reasonOverlayApplied is used for the event emitted on a claim whose spec
+// was assembled from one or more base claim overlays.
+const reasonOverlayApplied = "OverlaidSpec"
+
+// Error strings.
+const (
+	errFetchOverlay   = "cannot get base claim referenced by spec.overlays"
+	errOverlayCycle   = "spec.overlays contains a cycle"
+	errOverlayTooDeep = "spec.overlays chain exceeds the maximum supported depth"
+)
+
+// overlaySpecContextKey is the context key under which Configure stashes the
+// claim spec resolved from spec.overlays, for ConfigureSpec to consult
+// instead of the claim's own stored spec.
+type overlaySpecContextKey struct{}
+
+// withOverlaySpec returns a copy of ctx carrying spec, the claim's effective
+// spec once any base claim overlays have been merged in. It's a no-op if
+// spec is nil, i.e. the claim has no overlays.
+func withOverlaySpec(ctx context.Context, spec map[string]interface{}) context.Context {
+	if spec == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, overlaySpecContextKey{}, spec)
+}
+
+// overlaySpecFromContext returns the claim spec stashed by withOverlaySpec,
+// if any. It tolerates a nil ctx, which callers that don't resolve overlays
+// (e.g. ConfigureComposite) may pass through unchanged.
+func overlaySpecFromContext(ctx context.Context) (map[string]interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	spec, ok := ctx.Value(overlaySpecContextKey{}).(map[string]interface{})
+	return spec, ok
+}
+
+// An overlayRef identifies a base claim referenced by spec.overlays.
+type overlayRef struct {
+	Namespace string
+	Name      string
+}
+
+func (r overlayRef) String() string { return fmt.Sprintf("%s/%s", r.Namespace, r.Name) }
+
+// overlayRefs returns the base claims referenced by cm's spec.overlays, in
+// the order they were declared.
+func overlayRefs(cm *claim.Unstructured) []overlayRef {
+	spec, ok := cm.Object["spec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	raw, ok := spec["overlays"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	refs := make([]overlayRef, 0, len(raw))
+	for _, o := range raw {
+		m, ok := o.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ns, _ := m["namespace"].(string)
+		if ns == "" {
+			ns = cm.GetNamespace()
+		}
+		n, _ := m["name"].(string)
+		refs = append(refs, overlayRef{Namespace: ns, Name: n})
+	}
+	return refs
+}
+
+// resolveOverlays fetches cm's base claims (spec.overlays), in order, deep
+// merges their specs together, then merges cm's own spec on top of the
+// result. This lets a platform team publish a base claim with org defaults,
+// and an app team file a thin claim that overrides only what it cares
+// about. resolveOverlays does not modify cm; it returns the merged spec for
+// the caller to configure the composite resource with, so that a thin
+// claim's own stored spec never has its bases' defaults baked into it.
+// resolveOverlays returns a nil spec if cm has no overlays.
+func (c *APICompositeConfigurator) resolveOverlays(ctx context.Context, cm *claim.Unstructured) (map[string]interface{}, error) {
+	refs := overlayRefs(cm)
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	visited := map[overlayRef]bool{{Namespace: cm.GetNamespace(), Name: cm.GetName()}: true}
+
+	merged := map[string]interface{}{}
+	contributors := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		base, err := c.fetchOverlay(ctx, cm.GroupVersionKind(), ref, visited, 1)
+		if err != nil {
+			return nil, err
+		}
+		spec, _ := base.Object["spec"].(map[string]interface{})
+		merged = mergeOverlaySpec(merged, spec)
+		contributors = append(contributors, ref.String())
+	}
+
+	own, _ := cm.Object["spec"].(map[string]interface{})
+	merged = mergeOverlaySpec(merged, own)
+
+	if c.events != nil {
+		c.events.Event(cm, event.Normal(reasonOverlayApplied, fmt.Sprintf("Spec fields were contributed by base claim(s): %v", contributors)))
+	}
+	return merged, nil
+}
+
+// fetchOverlay fetches the base claim at ref, recursively resolving and
+// merging in its own overlays first so that a field it inherited from a
+// more deeply nested base is still visible to its dependents. visited
+// tracks ref's ancestors along the current path only, so that a claim
+// referenced by two distinct overlay paths (e.g. a shared base claim) isn't
+// mistaken for a cycle.
+func (c *APICompositeConfigurator) fetchOverlay(ctx context.Context, gvk schema.GroupVersionKind, ref overlayRef, visited map[overlayRef]bool, depth int) (*claim.Unstructured, error) {
+	if depth > maxOverlayDepth {
+		return nil, errors.New(errOverlayTooDeep)
+	}
+	if visited[ref] {
+		return nil, errors.New(errOverlayCycle)
+	}
+
+	base := claim.New(claim.WithGroupVersionKind(gvk))
+	if err := c.client.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, base); err != nil {
+		return nil, errors.Wrap(err, errFetchOverlay)
+	}
+
+	childVisited := withVisited(visited, ref)
+
+	baseSpec, _ := base.Object["spec"].(map[string]interface{})
+	inherited := map[string]interface{}{}
+	for _, child := range overlayRefs(base) {
+		grandparent, err := c.fetchOverlay(ctx, gvk, child, childVisited, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		grandSpec, _ := grandparent.Object["spec"].(map[string]interface{})
+		inherited = mergeOverlaySpec(inherited, grandSpec)
+	}
+	base.Object["spec"] = mergeOverlaySpec(inherited, baseSpec)
+
+	return base, nil
+}
+
+// withVisited returns a copy of visited with ref added, leaving visited
+// itself untouched so that sibling overlay paths don't see each other's
+// ancestors.
+func withVisited(visited map[overlayRef]bool, ref overlayRef) map[overlayRef]bool {
+	out := make(map[overlayRef]bool, len(visited)+1)
+	for k, v := range visited {
+		out[k] = v
+	}
+	out[ref] = true
+	return out
+}
+
+// mergeOverlaySpec deep-merges src onto dst. A map is merged key by key.
+// A scalar in src replaces dst's value. A list in src replaces dst's list,
+// unless it starts with a {"$patch": "merge"} marker, in which case any
+// element of src not already present (by deep equality) in dst is appended
+// to dst's list; a {"$patch": "replace"} marker is the (explicit) default
+// behavior.
+func mergeOverlaySpec(dst, src map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		out[k] = v
+	}
+
+	for k, sv := range src {
+		dv := out[k]
+		switch sv := sv.(type) {
+		case map[string]interface{}:
+			if dm, ok := dv.(map[string]interface{}); ok {
+				out[k] = mergeOverlaySpec(dm, sv)
+				continue
+			}
+			out[k] = sv
+		case []interface{}:
+			dl, _ := dv.([]interface{})
+			out[k] = mergeOverlayList(dl, sv)
+		default:
+			out[k] = sv
+		}
+	}
+
+	return out
+}
+
+// mergeOverlayList applies src onto dst per the $patch strategy documented
+// on mergeOverlaySpec.
+func mergeOverlayList(dst, src []interface{}) []interface{} {
+	patch := "replace"
+	elements := make([]interface{}, 0, len(src))
+	for _, e := range src {
+		if m, ok := e.(map[string]interface{}); ok {
+			if p, ok := m["$patch"].(string); ok {
+				patch = p
+				continue
+			}
+		}
+		elements = append(elements, e)
+	}
+
+	if patch != "merge" {
+		return elements
+	}
+
+	out := append([]interface{}{}, dst...)
+	for _, e := range elements {
+		if !listContains(out, e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func listContains(list []interface{}, e interface{}) bool {
+	for _, x := range list {
+		if equalJSON(x, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane/crossplane-runtime/pkg/resource/unstructured/claim"
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+)
+
+func TestMergeOverlayList(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		dst    []interface{}
+		src    []interface{}
+		want   []interface{}
+	}{
+		"OverlayListMergeStrategyReplace": {
+			reason: "By default (or with an explicit $patch: replace marker) src's list should replace dst's outright",
+			dst:    []interface{}{"a", "b"},
+			src:    []interface{}{"c"},
+			want:   []interface{}{"c"},
+		},
+		"OverlayListMergeStrategyMerge": {
+			reason: "A $patch: merge marker should append any element of src not already present in dst to dst's list",
+			dst:    []interface{}{"a", "b"},
+			src: []interface{}{
+				map[string]interface{}{"$patch": "merge"},
+				"b",
+				"c",
+			},
+			want: []interface{}{"a", "b", "c"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := mergeOverlayList(tc.dst, tc.src)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mergeOverlayList(...): %s\n-want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestResolveOverlays(t *testing.T) {
+	ns := "spacename"
+
+	base := func(name string, spec map[string]interface{}) *claim.Unstructured {
+		return &claim.Unstructured{
+			Unstructured: unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"namespace": ns,
+						"name":      name,
+					},
+					"spec": spec,
+				},
+			},
+		}
+	}
+
+	overlayOf := func(name string) map[string]interface{} {
+		return map[string]interface{}{"namespace": ns, "name": name}
+	}
+
+	cases := map[string]struct {
+		reason  string
+		client  client.Client
+		cm      *claim.Unstructured
+		want    map[string]interface{}
+		wantCm  *claim.Unstructured
+		wantErr error
+	}{
+		"NoOverlays": {
+			reason: "A claim with no spec.overlays should be left alone, and have a nil resolved spec",
+			client: test.NewMockClient(),
+			cm:     base("cool", map[string]interface{}{"coolness": float64(23)}),
+			wantCm: base("cool", map[string]interface{}{"coolness": float64(23)}),
+		},
+		"MergesBaseFieldsIntoThinClaim": {
+			reason: "A thin claim's spec should be merged on top of its base claim's, without modifying the thin claim itself",
+			client: &test.MockClient{
+				MockGet: func(_ context.Context, _ client.ObjectKey, obj client.Object) error {
+					o := obj.(*claim.Unstructured)
+					*o = *base("base", map[string]interface{}{
+						"region":  "us-east-1",
+						"storage": "10Gi",
+					})
+					return nil
+				},
+			},
+			cm: base("app", map[string]interface{}{
+				"overlays": []interface{}{overlayOf("base")},
+				"storage":  "100Gi",
+			}),
+			want: map[string]interface{}{
+				"overlays": []interface{}{overlayOf("base")},
+				"region":   "us-east-1",
+				"storage":  "100Gi",
+			},
+			wantCm: base("app", map[string]interface{}{
+				"overlays": []interface{}{overlayOf("base")},
+				"storage":  "100Gi",
+			}),
+		},
+		"OverlayDiamond": {
+			reason: "Two overlays that transitively reference the same common base is not a cycle",
+			client: &test.MockClient{
+				MockGet: func(_ context.Context, key client.ObjectKey, obj client.Object) error {
+					o := obj.(*claim.Unstructured)
+					switch key.Name {
+					case "left":
+						*o = *base("left", map[string]interface{}{
+							"overlays": []interface{}{overlayOf("shared")},
+						})
+					case "right":
+						*o = *base("right", map[string]interface{}{
+							"overlays": []interface{}{overlayOf("shared")},
+						})
+					default:
+						*o = *base(key.Name, map[string]interface{}{"region": "us-east-1"})
+					}
+					return nil
+				},
+			},
+			cm: base("app", map[string]interface{}{
+				"overlays": []interface{}{overlayOf("left"), overlayOf("right")},
+			}),
+			want: map[string]interface{}{
+				"overlays": []interface{}{overlayOf("left"), overlayOf("right")},
+				"region":   "us-east-1",
+			},
+		},
+		"OverlayCycle": {
+			reason: "A cycle in the overlay graph is an error",
+			client: &test.MockClient{
+				MockGet: func(_ context.Context, key client.ObjectKey, obj client.Object) error {
+					o := obj.(*claim.Unstructured)
+					*o = *base("cool", map[string]interface{}{
+						"overlays": []interface{}{overlayOf("cool")},
+					})
+					return nil
+				},
+			},
+			cm: base("app", map[string]interface{}{
+				"overlays": []interface{}{overlayOf("cool")},
+			}),
+			wantErr: errors.New(errOverlayCycle),
+		},
+		"OverlayDepthExceeded": {
+			reason: "An overlay chain deeper than maxOverlayDepth is an error",
+			client: &test.MockClient{
+				MockGet: func(_ context.Context, key client.ObjectKey, obj client.Object) error {
+					o := obj.(*claim.Unstructured)
+					next := key.Name + "x"
+					*o = *base(key.Name, map[string]interface{}{
+						"overlays": []interface{}{overlayOf(next)},
+					})
+					return nil
+				},
+			},
+			cm: base("app", map[string]interface{}{
+				"overlays": []interface{}{overlayOf("base")},
+			}),
+			wantErr: errors.New(errOverlayTooDeep),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &APICompositeConfigurator{client: tc.client}
+			got, err := c.resolveOverlays(context.Background(), tc.cm)
+			if diff := cmp.Diff(tc.wantErr, err, test.EquateErrors()); diff != "" {
+				t.Errorf("c.resolveOverlays(...): %s\n-want error, +got error:\n%s\n", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("c.resolveOverlays(...): %s\n-want, +got:\n%s\n", tc.reason, diff)
+			}
+			if tc.wantCm != nil {
+				if diff := cmp.Diff(tc.wantCm, tc.cm); diff != "" {
+					t.Errorf("c.resolveOverlays(...): %s\n-want cm unmodified, +got:\n%s\n", tc.reason, diff)
+				}
+			}
+		})
+	}
+}
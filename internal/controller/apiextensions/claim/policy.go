@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claim
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/pkg/apis/common/v1"
+)
+
+// A PropagationMode determines how the value at a given spec field flows
+// between a claim and its composite resource.
+type PropagationMode string
+
+// Propagation modes. ClaimToComposite is the implicit default for any field
+// that isn't covered by a PropagationPolicy.
+const (
+	// PropagationModeClaimToComposite copies the claim's value for a field
+	// onto the composite resource using a three-way merge: a value set
+	// directly on the composite resource is kept unless the claim's value
+	// has changed since it was last applied, and is also kept (and reported
+	// as a conflict) when both sides have changed it.
+	PropagationModeClaimToComposite PropagationMode = "ClaimToComposite"
+
+	// PropagationModeCompositeToClaim copies the composite resource's value
+	// for a field onto the claim, overwriting any value the claim set.
+	PropagationModeCompositeToClaim PropagationMode = "CompositeToClaim"
+
+	// PropagationModeLateInitClaimOnly copies the composite resource's value
+	// for a field onto the claim only when the claim hasn't set it itself.
+	PropagationModeLateInitClaimOnly PropagationMode = "LateInitClaimOnly"
+
+	// PropagationModeImmutable rejects any change the claim makes to a
+	// field once the claim's value for it has been recorded as last applied
+	// to the composite resource. A value the composite resource held before
+	// the claim's was first applied is overwritten by the claim's, and
+	// changes made directly on the composite resource are not checked.
+	PropagationModeImmutable PropagationMode = "Immutable"
+
+	// PropagationModeRejected rejects any value the claim sets for a field;
+	// it never reaches the composite resource.
+	PropagationModeRejected PropagationMode = "Rejected"
+)
+
+// TypePropagationRejected indicates whether a claim has one or more fields
+// that were rejected by a PropagationPolicy instead of being propagated to
+// its composite resource. It's a ConditionType distinct from xpv1.TypeSynced
+// so that a rejected field and a three-way merge conflict (which is
+// reported on TypeSynced) can both be surfaced on the claim at once, rather
+// than the second SetConditions call silently replacing the first.
+const TypePropagationRejected xpv1.ConditionType = "PropagationRejected"
+
+// ReasonInvalidPropagation is added to a claim's TypePropagationRejected
+// condition when one of its fields was rejected by a PropagationPolicy
+// instead of being propagated to its composite resource.
+const ReasonInvalidPropagation xpv1.ConditionReason = "InvalidPropagation"
+
+// errImmutableFieldChanged is formatted with the field path that violated a
+// PropagationModeImmutable policy.
+const errImmutableFieldChanged = "cannot change field %q after it has been set"
+
+// A PropagationPolicy governs how the value at Path flows between a claim
+// and its composite resource. Path is a top-level spec field name (e.g.
+// "region"); nested paths are not yet supported.
+type PropagationPolicy struct {
+	Path string
+	Mode PropagationMode
+}
+
+// A PropagationPolicySet resolves the PropagationMode that applies to a
+// given spec field.
+//
+// Callers of NewAPIClaimConfigurator and NewAPICompositeConfigurator build
+// a PropagationPolicySet themselves. Nothing in this package reads
+// propagation policies from a CompositeResourceDefinition, which has no
+// field for them, so policies cannot be configured per XRD.
+type PropagationPolicySet []PropagationPolicy
+
+// ModeFor returns the PropagationMode configured for the supplied field, or
+// PropagationModeClaimToComposite if the set has no policy for it.
+func (s PropagationPolicySet) ModeFor(field string) PropagationMode {
+	for _, p := range s {
+		if p.Path == field {
+			return p.Mode
+		}
+	}
+	return PropagationModeClaimToComposite
+}
+
+// invalidPropagation reports a condition explaining which claim fields were
+// rejected by policy rather than propagated to the composite resource. It's
+// reported on TypePropagationRejected, not TypeSynced, so it can coexist
+// with a condition reporting an unrelated merge conflict.
+func invalidPropagation(fields []string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:    TypePropagationRejected,
+		Status:  corev1.ConditionFalse,
+		Reason:  ReasonInvalidPropagation,
+		Message: fmt.Sprintf("Fields may not be set on this claim: %v", fields),
+	}
+}